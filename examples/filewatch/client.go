@@ -0,0 +1,175 @@
+// Copyright 2013 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the client.
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the client.
+	pongWait = 60 * time.Second
+
+	// Send pings to client with this period. Must be less than pongWait.
+	pingPeriod = (pongWait * 9) / 10
+
+	// Maximum message size allowed from client.
+	maxMessageSize = 512
+
+	// Lines queued on a client's send channel are coalesced into a single
+	// TextMessage up to this many bytes, so permessage-deflate has enough
+	// context to compress well.
+	maxBatchBytes = 64 * 1024
+
+	// If fewer than maxBatchBytes are available immediately, wait at most
+	// this long for more lines to arrive before flushing what we have.
+	flushInterval = 50 * time.Millisecond
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// Client is a middleman between the hub and a websocket connection.
+type Client struct {
+	hub *Hub
+
+	conn *websocket.Conn
+
+	// Buffered channel of outbound lines.
+	send chan []byte
+}
+
+// readPump only exists to keep the connection alive and to notice when the
+// client goes away; the client never sends us anything interesting.
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+// flush writes the buffered lines as a single TextMessage, if any, and
+// resets buf for reuse.
+func (c *Client) flush(buf *bytes.Buffer) error {
+	if buf.Len() == 0 {
+		return nil
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err := c.conn.WriteMessage(websocket.TextMessage, buf.Bytes())
+	buf.Reset()
+	return err
+}
+
+// writePump pumps lines from the hub to the websocket connection, batching
+// whatever is already queued on c.send (up to maxBatchBytes, or
+// flushInterval if the tailer is slower than that) into a single message.
+//
+// A goroutine running writePump is started for each connection. The
+// application ensures that there is at most one writer to a connection by
+// executing all writes from this goroutine.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	flush := time.NewTimer(flushInterval)
+	flush.Stop()
+	var buf bytes.Buffer
+	defer func() {
+		ticker.Stop()
+		flush.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				// The hub closed the channel.
+				c.flush(&buf)
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			startsBatch := buf.Len() == 0
+			buf.Write(message)
+
+		drain:
+			for buf.Len() < maxBatchBytes {
+				select {
+				case message, ok := <-c.send:
+					if !ok {
+						c.flush(&buf)
+						c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+						c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+						return
+					}
+					buf.Write(message)
+				default:
+					break drain
+				}
+			}
+
+			if buf.Len() >= maxBatchBytes {
+				if err := c.flush(&buf); err != nil {
+					return
+				}
+			} else if startsBatch {
+				// Arm the timer only when a batch starts, so later lines
+				// cannot push its flush back past flushInterval.
+				flush.Reset(flushInterval)
+			}
+		case <-flush.C:
+			if err := c.flush(&buf); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// serveWs handles websocket requests from clients, registering them with the
+// hub so they receive every line broadcast from the tailed files.
+func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		if _, ok := err.(websocket.HandshakeError); !ok {
+			log.Println(err)
+		}
+		return
+	}
+
+	if *compress {
+		ws.EnableWriteCompression(true)
+		ws.SetCompressionLevel(*compressLevel)
+	}
+
+	client := &Client{hub: hub, conn: ws, send: make(chan []byte, 256)}
+	client.hub.register <- client
+
+	go client.writePump()
+	go client.readPump()
+}
@@ -0,0 +1,220 @@
+// Copyright 2013 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the client.
+	execWriteWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the client.
+	execPongWait = 60 * time.Second
+
+	// Send pings to client with this period. Must be less than execPongWait.
+	execPingPeriod = (execPongWait * 9) / 10
+
+	// Maximum message size allowed from client; generous enough for pasted
+	// input, but bounded so a misbehaving client can't have us buffer an
+	// unlimited binary message in memory.
+	execMaxMessageSize = 32 * 1024
+)
+
+// resizeMessage is the control message clients send to report a terminal
+// resize; it is distinguished from input by being a TextMessage rather than
+// a BinaryMessage.
+type resizeMessage struct {
+	Type string `json:"type"`
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+// serveExec runs execCmd under a pty and streams its output to the
+// websocket, forwarding inbound binary messages to the pty's stdin. The
+// initial window size comes from the cols and rows query parameters.
+func serveExec(w http.ResponseWriter, r *http.Request, execCmd string) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		if _, ok := err.(websocket.HandshakeError); !ok {
+			log.Println(err)
+		}
+		return
+	}
+	defer ws.Close()
+
+	fields := strings.Fields(execCmd)
+	if len(fields) == 0 {
+		log.Println("exec: empty command")
+		return
+	}
+
+	size := &pty.Winsize{Cols: 80, Rows: 24}
+	if cols, err := strconv.Atoi(r.URL.Query().Get("cols")); err == nil && cols > 0 {
+		size.Cols = uint16(cols)
+	}
+	if rows, err := strconv.Atoi(r.URL.Query().Get("rows")); err == nil && rows > 0 {
+		size.Rows = uint16(rows)
+	}
+
+	cmd := exec.Command(fields[0], fields[1:]...)
+	f, err := pty.StartWithSize(cmd, size)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+
+	// As with client.go's readPump, bound the size of inbound messages and
+	// reap the connection if pongs stop arriving (e.g. the client's network
+	// disappears without a clean TCP close).
+	ws.SetReadLimit(execMaxMessageSize)
+	ws.SetReadDeadline(time.Now().Add(execPongWait))
+	ws.SetPongHandler(func(string) error {
+		ws.SetReadDeadline(time.Now().Add(execPongWait))
+		return nil
+	})
+
+	// websocket only allows one concurrent writer, so pty output is funneled
+	// through out and written, along with pings, by a single writeExecPump
+	// goroutine instead of writing directly from ptyToChan.
+	out := make(chan []byte, 16)
+	pumpDone := make(chan struct{})
+	go func() {
+		ptyToChan(f, out)
+		close(pumpDone)
+	}()
+
+	readDone := make(chan struct{})
+	go func() {
+		wsToPty(ws, f)
+		close(readDone)
+	}()
+
+	writeDone := make(chan struct{})
+	go func() {
+		writeExecPump(ws, out)
+		close(writeDone)
+	}()
+
+	// ptyToChan/wsToPty each block on their own reader, so whichever side
+	// ends first (the process exiting, or the client going away) has to
+	// actively tear down the other: kill the process and close the pty to
+	// unblock ptyToChan, or nudge the websocket's read deadline to unblock
+	// wsToPty.
+	select {
+	case <-pumpDone:
+		cmd.Process.Kill()
+		f.Close()
+		ws.SetReadDeadline(time.Now())
+		<-readDone
+	case <-readDone:
+		cmd.Process.Kill()
+		f.Close()
+		<-pumpDone
+	}
+	close(out)
+	<-writeDone
+
+	status := 0
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = exitErr.ExitCode()
+		} else {
+			status = 1
+		}
+	}
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, strconv.Itoa(status))
+	ws.SetWriteDeadline(time.Now().Add(execWriteWait))
+	ws.WriteMessage(websocket.CloseMessage, closeMsg)
+}
+
+// ptyToChan reads pty output into out until the pty is closed or reading
+// fails.
+func ptyToChan(f *os.File, out chan<- []byte) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			b := make([]byte, n)
+			copy(b, buf[:n])
+			out <- b
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeExecPump is the sole writer to ws: it relays pty output queued on out
+// as binary frames and sends periodic pings, until out is closed or a write
+// fails. Once writing fails it keeps draining out (without writing) until
+// serveExec closes it, so ptyToChan's send on out never blocks forever
+// waiting for a writer that has already given up.
+func writeExecPump(ws *websocket.Conn, out <-chan []byte) {
+	ticker := time.NewTicker(execPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case b, ok := <-out:
+			if !ok {
+				return
+			}
+			ws.SetWriteDeadline(time.Now().Add(execWriteWait))
+			if err := ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+				drainExecChan(out)
+				return
+			}
+		case <-ticker.C:
+			ws.SetWriteDeadline(time.Now().Add(execWriteWait))
+			if err := ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				drainExecChan(out)
+				return
+			}
+		}
+	}
+}
+
+// drainExecChan discards everything sent on out until it is closed, so a
+// still-running sender never blocks forever on a send that nobody is
+// writing to ws anymore.
+func drainExecChan(out <-chan []byte) {
+	for range out {
+	}
+}
+
+// wsToPty forwards inbound binary messages to the pty's stdin and applies
+// resize control messages to the pty's window size.
+func wsToPty(ws *websocket.Conn, f *os.File) {
+	for {
+		mt, p, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch mt {
+		case websocket.BinaryMessage:
+			if _, err := f.Write(p); err != nil {
+				return
+			}
+		case websocket.TextMessage:
+			var m resizeMessage
+			if err := json.Unmarshal(p, &m); err != nil || m.Type != "resize" {
+				continue
+			}
+			pty.Setsize(f, &pty.Winsize{Cols: m.Cols, Rows: m.Rows})
+		}
+	}
+}
@@ -5,10 +5,8 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"html/template"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -16,33 +14,27 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/supereagle/websocket/examples/filewatch/tailer"
 )
 
 const (
-	// Time allowed to write the file to the client.
-	writeWait = 10 * time.Second
-
-	// Time allowed to read the next pong message from the client.
-	pongWait = 60 * time.Second
-
-	// Send pings to client with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
-
 	// Poll file for changes with this period.
 	filePeriod = 5 * time.Second
 )
 
 var (
-	addr      = flag.String("addr", ":8080", "http service address")
+	addr     = flag.String("addr", ":8080", "http service address")
+	demo     = flag.Bool("demo", false, "periodically append demo lines to the tailed files")
+	compress = flag.Bool("compress", false, "enable permessage-deflate compression; trades CPU for "+
+		"bandwidth, which usually pays off for highly-repetitive log text")
+	compressLevel = flag.Int("compress-level", 1, "deflate compression level, 1 (fastest) to 9 "+
+		"(smallest); only used when -compress is set")
+	execCmd = flag.String("exec", "", "run `cmd args...` under a pty and stream its output "+
+		"instead of tailing a file")
+
 	homeTempl = template.Must(template.New("").Parse(homeHTML))
+	execTempl = template.Must(template.New("").Parse(execHomeHTML))
 	filename  []string
-	upgrader  = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-	}
-
-	rd *bufio.Reader 
 )
 
 func readFileIfModified(lastMod time.Time) ([]byte, time.Time, error) {
@@ -60,147 +52,61 @@ func readFileIfModified(lastMod time.Time) ([]byte, time.Time, error) {
 	return p, fi.ModTime(), nil
 }
 
-func tailFile(filename []string, data chan []byte, stop chan int) {
-	f1, err := os.Open(filename[0])
-	if err != nil {
-		data <- []byte("Interval error happens, TERMINATE")
-		stop <- 1
-		return
-	}
-	defer f1.Close()
-	rd = bufio.NewReader(f1)
-
-	if len(filename) > 1 {
-		f2, err := os.Open(filename[1])
-		if err == nil {
-			go changeTailFile(f2)
-		}
-		defer f2.Close()
-	}
-	
+// tailFile follows path with a tailer.Tailer and broadcasts every chunk of
+// newly written bytes to hub. One goroutine is started per tailed file, so
+// clients of the same file share a single reader instead of each opening
+// their own.
+func tailFile(path string, hub *Hub) {
+	_, data, errc := tailer.NewTailer(path, true)
 	for {
-		line, err := rd.ReadBytes('\n')
-
-		if io.EOF == err {
-			continue
-		}
-
-		if err != nil  {
-			data <- []byte("Interval error happens, TERMINATE")
-			stop <- 1
-			break
-		}
-		data <- line
-	}
-}
-
-func changeTailFile(file *os.File) {
-	time.Sleep( 20 * time.Second)
-	rd = bufio.NewReader(file)
-}
-
-func reader(ws *websocket.Conn) {
-	defer ws.Close()
-	ws.SetReadLimit(512)
-	ws.SetReadDeadline(time.Now().Add(pongWait))
-	ws.SetPongHandler(func(string) error { ws.SetReadDeadline(time.Now().Add(pongWait)); return nil })
-	for {
-		_, _, err := ws.ReadMessage()
-		if err != nil {
-			break
+		select {
+		case b, ok := <-data:
+			if !ok {
+				return
+			}
+			hub.broadcast <- b
+		case err := <-errc:
+			if err != nil {
+				log.Println(err)
+			}
+			return
 		}
 	}
 }
 
+// appendFile periodically appends demo lines to the tailed files. It is only
+// started when the -demo flag is set, and runs once for the whole process
+// rather than once per connection.
 func appendFile() {
 	var file2 *os.File
 
-	file1, err := os.OpenFile(filename[0], os.O_APPEND | os.O_RDWR, 0666)
-	defer file1.Close()
-
+	file1, err := os.OpenFile(filename[0], os.O_APPEND|os.O_RDWR, 0666)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer file1.Close()
 
 	if len(filename) > 1 {
-		file2, err = os.OpenFile(filename[1], os.O_APPEND | os.O_RDWR, 0666)
-		defer file2.Close()
-	
+		file2, err = os.OpenFile(filename[1], os.O_APPEND|os.O_RDWR, 0666)
 		if err != nil {
 			log.Fatal(err)
 		}
+		defer file2.Close()
 	}
 
 	fileTicker := time.NewTicker(filePeriod)
-	for {
-		select {
-		case <-fileTicker.C:
-			_, err = file1.WriteString("hello a\n")
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			if len(filename) > 1 {
-				_, err = file2.WriteString("hello b\n")
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
+	for range fileTicker.C {
+		if _, err = file1.WriteString("hello a\n"); err != nil {
+			log.Fatal(err)
 		}
-	}
-}
-
-func writer(ws *websocket.Conn, lastMod time.Time) {
-	data := make(chan []byte, 10)
-	var p []byte
-	stop := make(chan int, 0)
-
-	pingTicker := time.NewTicker(pingPeriod)
-	defer func() {
-		pingTicker.Stop()
-		ws.Close()
-	}()
-
-	go tailFile(filename, data, stop)
-	for {
-		select {
-		case p = <-data:
-			ws.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := ws.WriteMessage(websocket.TextMessage, p); err != nil {
-				return
-			}
-		case <-stop:
-			ws.SetWriteDeadline(time.Now().Add(writeWait))
-			ws.WriteMessage(websocket.CloseMessage, []byte{})
-			return
-		case <-pingTicker.C:
-			ws.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := ws.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
-				return
+		if file2 != nil {
+			if _, err = file2.WriteString("hello b\n"); err != nil {
+				log.Fatal(err)
 			}
 		}
 	}
 }
 
-func serveWs(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		if _, ok := err.(websocket.HandshakeError); !ok {
-			log.Println(err)
-		}
-		return
-	}
-
-	var lastMod time.Time
-	if n, err := strconv.ParseInt(r.FormValue("lastMod"), 16, 64); err == nil {
-		lastMod = time.Unix(0, n)
-	}
-
-	go writer(ws, lastMod)
-	go appendFile()
-	reader(ws)
-}
-
 func serveHome(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		http.Error(w, "Not found", 404)
@@ -228,14 +134,57 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	homeTempl.Execute(w, &v)
 }
 
+func serveExecHome(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.Error(w, "Not found", 404)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	execTempl.Execute(w, &struct{ Host string }{r.Host})
+}
+
 func main() {
 	flag.Parse()
+
+	if *compress {
+		upgrader.EnableCompression = true
+	}
+
+	if *execCmd != "" {
+		http.HandleFunc("/", serveExecHome)
+		http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+			serveExec(w, r, *execCmd)
+		})
+		if err := http.ListenAndServe(*addr, nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if flag.NArg() < 1 {
 		log.Fatal("filename not specified")
 	}
 	filename = flag.Args()[0:]
+
+	hub := newHub()
+	go hub.run()
+
+	for _, fn := range filename {
+		go tailFile(fn, hub)
+	}
+
+	if *demo {
+		go appendFile()
+	}
+
 	http.HandleFunc("/", serveHome)
-	http.HandleFunc("/ws", serveWs)
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		serveWs(hub, w, r)
+	})
 	if err := http.ListenAndServe(*addr, nil); err != nil {
 		log.Fatal(err)
 	}
@@ -264,3 +213,34 @@ const homeHTML = `<!DOCTYPE html>
     </body>
 </html>
 `
+
+const execHomeHTML = `<!DOCTYPE html>
+<html lang="en">
+    <head>
+        <title>WebSocket Example</title>
+    </head>
+    <body>
+        <pre id="termData"></pre>
+        <script type="text/javascript">
+            (function() {
+                var data = document.getElementById("termData");
+                var cols = 80, rows = 24;
+                var conn = new WebSocket("ws://{{.Host}}/ws?cols=" + cols + "&rows=" + rows);
+                conn.binaryType = "arraybuffer";
+                conn.onclose = function(evt) {
+                    data.textContent += '\nConnection closed: ' + evt.reason;
+                }
+                conn.onmessage = function(evt) {
+                    data.textContent += new TextDecoder().decode(evt.data);
+                }
+                document.addEventListener("keypress", function(evt) {
+                    conn.send(new TextEncoder().encode(evt.key));
+                });
+                window.addEventListener("resize", function() {
+                    conn.send(JSON.stringify({type: "resize", cols: cols, rows: rows}));
+                });
+            })();
+        </script>
+    </body>
+</html>
+`
@@ -0,0 +1,175 @@
+// Copyright 2013 The Gorilla WebSocket Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tailer follows the growing tail of a file, coping with
+// truncation, rotation and rename the way `tail -F` does.
+package tailer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Tailer streams newly appended bytes of a single file.
+type Tailer struct {
+	path   string
+	file   *os.File
+	offset int64
+
+	data chan []byte
+	errc chan error
+}
+
+// NewTailer starts tailing path in the background. If fromEnd is true, only
+// bytes written after the call to NewTailer are delivered; otherwise the
+// file's existing content is delivered first. The returned channels are
+// closed when the tailer gives up, after sending the terminal error on errc.
+func NewTailer(path string, fromEnd bool) (*Tailer, <-chan []byte, <-chan error) {
+	t := &Tailer{
+		path: path,
+		data: make(chan []byte),
+		errc: make(chan error, 1),
+	}
+	go t.run(fromEnd)
+	return t, t.data, t.errc
+}
+
+func (t *Tailer) run(fromEnd bool) {
+	defer close(t.data)
+	defer close(t.errc)
+
+	if err := t.open(fromEnd); err != nil {
+		t.errc <- err
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.errc <- err
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(t.path); err != nil {
+		t.errc <- err
+		return
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Name != t.path {
+				continue
+			}
+			switch {
+			case event.Op&fsnotify.Write == fsnotify.Write:
+				if err := t.readNew(); err != nil {
+					t.errc <- err
+					return
+				}
+			case event.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+				t.file.Close()
+				watcher.Remove(t.path)
+				if err := t.waitForCreate(watcher); err != nil {
+					t.errc <- err
+					return
+				}
+				if err := t.open(false); err != nil {
+					t.errc <- err
+					return
+				}
+				if err := watcher.Add(t.path); err != nil {
+					t.errc <- err
+					return
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			t.errc <- err
+			return
+		}
+	}
+}
+
+// open (re)opens t.path, positioning the read offset at the start of the
+// file or its current end depending on fromEnd.
+func (t *Tailer) open(fromEnd bool) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	t.file = f
+	t.offset = 0
+	if fromEnd {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		t.offset = fi.Size()
+	}
+	return t.readNew()
+}
+
+// readNew delivers any bytes written since the last read offset, resetting
+// to the start of the file if it has been truncated.
+func (t *Tailer) readNew() error {
+	fi, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < t.offset {
+		t.offset = 0
+	}
+	if fi.Size() == t.offset {
+		return nil
+	}
+
+	if _, err := t.file.Seek(t.offset, os.SEEK_SET); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(t.file)
+	if err != nil {
+		return err
+	}
+	t.offset += int64(len(b))
+	if len(b) > 0 {
+		t.data <- b
+	}
+	return nil
+}
+
+// waitForCreate blocks until t.path reappears in its parent directory,
+// e.g. after log rotation recreates it.
+func (t *Tailer) waitForCreate(watcher *fsnotify.Watcher) error {
+	dir := filepath.Dir(t.path)
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+	defer watcher.Remove(dir)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Name == t.path && event.Op&fsnotify.Create == fsnotify.Create {
+				return nil
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		}
+	}
+}